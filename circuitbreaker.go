@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// circuitState is the state of a single target's circuit breaker.
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// targetHealthState tracks passive health for one backend target. It lives
+// in ProxyServer.targetHealth, keyed by "ip:port".
+type targetHealthState struct {
+	mu               sync.Mutex
+	state            circuitState
+	consecutiveFails int
+	openUntil        time.Time
+}
+
+// circuitBreakerConfig holds the tunables for the resilience layer, loaded
+// once at startup from environment variables.
+type circuitBreakerConfig struct {
+	// failureThreshold is the number of consecutive failures before a
+	// target's circuit opens.
+	failureThreshold int
+	// baseCooldown is how long the circuit stays open after the first trip.
+	baseCooldown time.Duration
+	// maxCooldown caps the exponential backoff applied to repeat trips.
+	maxCooldown time.Duration
+	// maxRetries is the number of additional attempts allowed for
+	// idempotent requests, on top of the first.
+	maxRetries int
+	// retryableStatus lists upstream status codes that should be treated
+	// as failures worth retrying against a different target.
+	retryableStatus map[int]bool
+}
+
+func loadCircuitBreakerConfig() circuitBreakerConfig {
+	cfg := circuitBreakerConfig{
+		failureThreshold: 5,
+		baseCooldown:     5 * time.Second,
+		maxCooldown:      2 * time.Minute,
+		maxRetries:       2,
+		retryableStatus: map[int]bool{
+			http.StatusBadGateway:         true,
+			http.StatusServiceUnavailable: true,
+			http.StatusGatewayTimeout:     true,
+		},
+	}
+
+	if n, ok := envInt("PROXY_CB_FAILURE_THRESHOLD"); ok && n > 0 {
+		cfg.failureThreshold = n
+	}
+	if n, ok := envInt("PROXY_CB_BASE_COOLDOWN_SECONDS"); ok && n > 0 {
+		cfg.baseCooldown = time.Duration(n) * time.Second
+	}
+	if n, ok := envInt("PROXY_CB_MAX_COOLDOWN_SECONDS"); ok && n > 0 {
+		cfg.maxCooldown = time.Duration(n) * time.Second
+	}
+	if n, ok := envInt("PROXY_CB_MAX_RETRIES"); ok && n >= 0 {
+		cfg.maxRetries = n
+	}
+	if v := os.Getenv("PROXY_CB_RETRYABLE_STATUS_CODES"); v != "" {
+		codes := make(map[int]bool)
+		for _, part := range strings.Split(v, ",") {
+			if n, err := strconv.Atoi(strings.TrimSpace(part)); err == nil {
+				codes[n] = true
+			}
+		}
+		if len(codes) > 0 {
+			cfg.retryableStatus = codes
+		}
+	}
+
+	return cfg
+}
+
+func envInt(key string) (int, bool) {
+	v := os.Getenv(key)
+	if v == "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+func targetHealthKey(ip, port string) string {
+	return fmt.Sprintf("%s:%s", ip, port)
+}
+
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// isTargetAvailable reports whether a request may be sent to key. If the
+// circuit is open past its cooldown, it transitions the target to
+// half-open and allows exactly one probe request through.
+func (p *ProxyServer) isTargetAvailable(key string) (available bool, halfOpenProbe bool) {
+	val, ok := p.targetHealth.Load(key)
+	if !ok {
+		return true, false
+	}
+	th := val.(*targetHealthState)
+	th.mu.Lock()
+	defer th.mu.Unlock()
+
+	switch th.state {
+	case circuitClosed:
+		return true, false
+	case circuitHalfOpen:
+		// A probe is already in flight; keep rejecting until it resolves.
+		return false, false
+	case circuitOpen:
+		if time.Now().Before(th.openUntil) {
+			return false, false
+		}
+		th.state = circuitHalfOpen
+		return true, true
+	default:
+		return true, false
+	}
+}
+
+// recordTargetSuccess closes the circuit and resets failure bookkeeping for
+// key. Called for both a normal closed-circuit success and a successful
+// half-open probe.
+func (p *ProxyServer) recordTargetSuccess(key string) {
+	val, ok := p.targetHealth.Load(key)
+	if !ok {
+		return
+	}
+	th := val.(*targetHealthState)
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	th.state = circuitClosed
+	th.consecutiveFails = 0
+}
+
+// recordTargetFailure registers a failed request against key, tripping or
+// re-tripping the circuit once the configured threshold is reached. A
+// failed half-open probe re-opens the circuit with exponential backoff.
+func (p *ProxyServer) recordTargetFailure(key string) {
+	val, _ := p.targetHealth.LoadOrStore(key, &targetHealthState{})
+	th := val.(*targetHealthState)
+	th.mu.Lock()
+	defer th.mu.Unlock()
+
+	th.consecutiveFails++
+
+	if th.state == circuitHalfOpen {
+		th.state = circuitOpen
+		th.openUntil = time.Now().Add(p.cbConfig.backoff(th.consecutiveFails))
+		return
+	}
+
+	if th.consecutiveFails >= p.cbConfig.failureThreshold {
+		th.state = circuitOpen
+		th.openUntil = time.Now().Add(p.cbConfig.backoff(th.consecutiveFails))
+	}
+}
+
+// backoff computes the open-circuit cooldown for the given number of
+// consecutive failures, doubling past failureThreshold and capping at
+// maxCooldown.
+func (cfg circuitBreakerConfig) backoff(consecutiveFails int) time.Duration {
+	exp := consecutiveFails - cfg.failureThreshold
+	if exp < 0 {
+		exp = 0
+	}
+	if exp > 10 {
+		exp = 10 // guard against overflow from a long streak of failures
+	}
+	cooldown := cfg.baseCooldown * time.Duration(math.Pow(2, float64(exp)))
+	if cooldown > cfg.maxCooldown {
+		cooldown = cfg.maxCooldown
+	}
+	return cooldown
+}
+
+// maxBufferedResponseBody caps how much of a response bufferedResponse will
+// hold in memory before committing to streaming it straight to the real
+// client. Keeps a slow/huge upstream (SSE, chunked downloads, big files)
+// from exhausting memory on a proxy handling many concurrent requests.
+const maxBufferedResponseBody = 4 << 20 // 4MiB
+
+// bufferedResponse captures a proxied response so ServeHTTP can decide
+// whether to retry before writing anything to the real client. Once the
+// body grows past maxBufferedResponseBody, it gives up on buffering,
+// commits what it has to the real ResponseWriter, and streams the rest
+// straight through instead of continuing to grow the in-memory copy.
+type bufferedResponse struct {
+	real http.ResponseWriter
+
+	header     http.Header
+	body       []byte
+	statusCode int
+
+	// streaming is set once the response has been committed to real. A
+	// streaming response is no longer retryable, since bytes have already
+	// reached the client.
+	streaming bool
+}
+
+func newBufferedResponse(real http.ResponseWriter) *bufferedResponse {
+	return &bufferedResponse{real: real, header: make(http.Header), statusCode: http.StatusOK}
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	if b.streaming {
+		return b.real.Header()
+	}
+	return b.header
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if !b.streaming && len(b.body)+len(p) > maxBufferedResponseBody {
+		b.commitToStreaming()
+	}
+	if b.streaming {
+		return b.real.Write(p)
+	}
+	b.body = append(b.body, p...)
+	return len(p), nil
+}
+
+func (b *bufferedResponse) WriteHeader(statusCode int) {
+	b.statusCode = statusCode
+	if b.streaming {
+		b.real.WriteHeader(statusCode)
+	}
+}
+
+// commitToStreaming flushes the headers, status code and body buffered so
+// far to the real ResponseWriter and switches Write into passthrough mode
+// for the rest of the response.
+func (b *bufferedResponse) commitToStreaming() {
+	dst := b.real.Header()
+	for k, v := range b.header {
+		dst[k] = v
+	}
+	b.real.WriteHeader(b.statusCode)
+	b.real.Write(b.body)
+	b.body = nil
+	b.streaming = true
+}
+
+// flush writes the buffered response to w. A no-op if the response already
+// committed to streaming, since it was written directly as it arrived.
+func (b *bufferedResponse) flush(w http.ResponseWriter) {
+	if b.streaming {
+		return
+	}
+	dst := w.Header()
+	for k, v := range b.header {
+		dst[k] = v
+	}
+	w.WriteHeader(b.statusCode)
+	w.Write(b.body)
+}
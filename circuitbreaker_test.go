@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff_CapsAtMaxCooldown(t *testing.T) {
+	cfg := circuitBreakerConfig{
+		failureThreshold: 3,
+		baseCooldown:     time.Second,
+		maxCooldown:      10 * time.Second,
+	}
+
+	if got := cfg.backoff(1); got != cfg.baseCooldown {
+		t.Errorf("backoff(1) = %v, want %v (below threshold, no doubling yet)", got, cfg.baseCooldown)
+	}
+	if got := cfg.backoff(3); got != cfg.baseCooldown {
+		t.Errorf("backoff(3) = %v, want %v (first trip)", got, cfg.baseCooldown)
+	}
+	if got := cfg.backoff(4); got != 2*cfg.baseCooldown {
+		t.Errorf("backoff(4) = %v, want %v (doubled once)", got, 2*cfg.baseCooldown)
+	}
+	if got := cfg.backoff(20); got != cfg.maxCooldown {
+		t.Errorf("backoff(20) = %v, want %v (capped)", got, cfg.maxCooldown)
+	}
+}
+
+func TestBackoff_NeverExceedsMaxCooldown(t *testing.T) {
+	cfg := circuitBreakerConfig{
+		failureThreshold: 1,
+		baseCooldown:     time.Second,
+		maxCooldown:      5 * time.Second,
+	}
+
+	for fails := 1; fails <= 50; fails++ {
+		if got := cfg.backoff(fails); got > cfg.maxCooldown {
+			t.Fatalf("backoff(%d) = %v, exceeds maxCooldown %v", fails, got, cfg.maxCooldown)
+		}
+	}
+}
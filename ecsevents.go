@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// ecsEventSubscriberConfig controls the optional SQS-backed ECS task
+// state-change subscriber. It's disabled when no queue URL is configured.
+type ecsEventSubscriberConfig struct {
+	queueURL string
+}
+
+func loadECSEventSubscriberConfig() ecsEventSubscriberConfig {
+	return ecsEventSubscriberConfig{queueURL: os.Getenv("PROXY_ECS_EVENTS_QUEUE_URL")}
+}
+
+// ecsTaskStateChangeDetail is the subset of an ECS Task State Change
+// EventBridge event this proxy acts on. See
+// https://docs.aws.amazon.com/AmazonECS/latest/developerguide/ecs_cwe_events.html
+type ecsTaskStateChangeDetail struct {
+	ClusterArn string `json:"clusterArn"`
+	Group      string `json:"group"` // "service:<service-name>"
+	LastStatus string `json:"lastStatus"`
+}
+
+type eventBridgeEvent struct {
+	DetailType string                   `json:"detail-type"`
+	Detail     ecsTaskStateChangeDetail `json:"detail"`
+}
+
+// runECSEventSubscriber long-polls the configured SQS queue for ECS task
+// state-change events (populated by an EventBridge rule) and invalidates
+// the affected service's task cache as soon as a task stops or starts,
+// rather than waiting for the next 30s poll. No-op if no queue is
+// configured.
+func (p *ProxyServer) runECSEventSubscriber(ctx context.Context) {
+	if p.ecsEventsConfig.queueURL == "" {
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		out, err := p.sqsClient.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            &p.ecsEventsConfig.queueURL,
+			MaxNumberOfMessages: 10,
+			WaitTimeSeconds:     20,
+		})
+		if err != nil {
+			slog.Error("ECS event subscriber: ReceiveMessage failed", "error", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		for _, msg := range out.Messages {
+			p.handleECSEventMessage(ctx, msg)
+		}
+	}
+}
+
+func (p *ProxyServer) handleECSEventMessage(ctx context.Context, msg sqsTypes.Message) {
+	defer p.deleteECSEventMessage(ctx, msg)
+
+	if msg.Body == nil {
+		return
+	}
+
+	var event eventBridgeEvent
+	if err := json.Unmarshal([]byte(*msg.Body), &event); err != nil {
+		slog.Error("ECS event subscriber: malformed message", "error", err)
+		return
+	}
+	if event.DetailType != "ECS Task State Change" {
+		return
+	}
+
+	switch event.Detail.LastStatus {
+	case "STOPPED", "STOPPING", "RUNNING":
+	default:
+		return
+	}
+
+	serviceName := strings.TrimPrefix(event.Detail.Group, "service:")
+	if serviceName == "" {
+		return
+	}
+	cluster := clusterNameFromArn(event.Detail.ClusterArn)
+
+	p.invalidateServiceCache(ctx, serviceName, cluster)
+	slog.Info("ECS event subscriber: invalidated cache", "service", serviceName, "cluster", cluster, "status", event.Detail.LastStatus)
+}
+
+func (p *ProxyServer) deleteECSEventMessage(ctx context.Context, msg sqsTypes.Message) {
+	if msg.ReceiptHandle == nil {
+		return
+	}
+	if _, err := p.sqsClient.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      &p.ecsEventsConfig.queueURL,
+		ReceiptHandle: msg.ReceiptHandle,
+	}); err != nil {
+		slog.Error("ECS event subscriber: DeleteMessage failed", "error", err)
+	}
+}
+
+// invalidateServiceCache drops both the shared Redis task cache and this
+// replica's in-memory copy for a service, forcing the next request to
+// re-fetch from ECS instead of reusing a stale task list.
+func (p *ProxyServer) invalidateServiceCache(ctx context.Context, serviceName, cluster string) {
+	redisKey := taskCacheKey(serviceName, cluster)
+	if err := p.rdb.Del(ctx, redisKey).Err(); err != nil {
+		slog.Error("ECS event subscriber: failed to invalidate Redis cache", "redis_key", redisKey, "error", err)
+	}
+	p.loadBalancer.Delete(poolCacheKey(cluster, serviceName))
+}
+
+// clusterNameFromArn extracts the short cluster name from a cluster ARN,
+// matching the short name RouteRule.Cluster and the cache keys derived from
+// it use (see discoverRoutes).
+func clusterNameFromArn(arn string) string {
+	parts := strings.Split(arn, "/")
+	return parts[len(parts)-1]
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// healthCheckConfig controls the active prober, loaded once at startup from
+// environment variables. Unlike the passive circuit breaker, this probes
+// targets on a fixed schedule regardless of live traffic.
+type healthCheckConfig struct {
+	enabled bool
+	// probeType is "tcp" (dial only) or "http" (GET path, check status).
+	probeType          string
+	path               string
+	expectedStatus     int
+	interval           time.Duration
+	timeout            time.Duration
+	healthyThreshold   int
+	unhealthyThreshold int
+}
+
+func loadHealthCheckConfig() healthCheckConfig {
+	cfg := healthCheckConfig{
+		enabled:            os.Getenv("PROXY_HEALTHCHECK_ENABLED") == "true",
+		probeType:          "tcp",
+		path:               "/",
+		expectedStatus:     http.StatusOK,
+		interval:           10 * time.Second,
+		timeout:            2 * time.Second,
+		healthyThreshold:   2,
+		unhealthyThreshold: 3,
+	}
+
+	if v := os.Getenv("PROXY_HEALTHCHECK_TYPE"); v == "http" || v == "tcp" {
+		cfg.probeType = v
+	}
+	if v := os.Getenv("PROXY_HEALTHCHECK_PATH"); v != "" {
+		cfg.path = v
+	}
+	if n, ok := envInt("PROXY_HEALTHCHECK_EXPECTED_STATUS"); ok && n > 0 {
+		cfg.expectedStatus = n
+	}
+	if n, ok := envInt("PROXY_HEALTHCHECK_INTERVAL_SECONDS"); ok && n > 0 {
+		cfg.interval = time.Duration(n) * time.Second
+	}
+	if n, ok := envInt("PROXY_HEALTHCHECK_TIMEOUT_SECONDS"); ok && n > 0 {
+		cfg.timeout = time.Duration(n) * time.Second
+	}
+	if n, ok := envInt("PROXY_HEALTHCHECK_HEALTHY_THRESHOLD"); ok && n > 0 {
+		cfg.healthyThreshold = n
+	}
+	if n, ok := envInt("PROXY_HEALTHCHECK_UNHEALTHY_THRESHOLD"); ok && n > 0 {
+		cfg.unhealthyThreshold = n
+	}
+
+	return cfg
+}
+
+// activeProbeState tracks this replica's consecutive probe results for one
+// target, used to decide when to flip its shared healthy/unhealthy state.
+type activeProbeState struct {
+	mu                 sync.Mutex
+	consecutiveSuccess int
+	consecutiveFailure int
+	healthy            bool
+}
+
+func healthRedisKey(target string) string {
+	return fmt.Sprintf("health:%s", target)
+}
+
+// runActiveHealthChecks probes every target backing a currently-routed
+// service on a fixed interval, independent of the 30s ECS task refresh, so
+// a failing backend is ejected after unhealthyThreshold consecutive failed
+// probes instead of waiting for the next poll. No-op if active health checks aren't enabled.
+func (p *ProxyServer) runActiveHealthChecks(ctx context.Context) {
+	if !p.hcConfig.enabled {
+		return
+	}
+
+	ticker := time.NewTicker(p.hcConfig.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.probeAllTargets(ctx)
+		}
+	}
+}
+
+// probeAllTargets probes, in parallel, every task currently backing a
+// routed service.
+func (p *ProxyServer) probeAllTargets(ctx context.Context) {
+	for _, rule := range p.router.snapshot() {
+		serviceInfo, err := p.getNextTarget(ctx, rule.ServiceName, rule.Cluster)
+		if err != nil {
+			continue
+		}
+		for _, ip := range serviceInfo.Tasks {
+			target := targetHealthKey(ip, rule.Port)
+			go p.probeAndRecord(ctx, target)
+		}
+	}
+}
+
+func (p *ProxyServer) probeAndRecord(ctx context.Context, target string) {
+	probeCtx, cancel := context.WithTimeout(ctx, p.hcConfig.timeout)
+	defer cancel()
+
+	ok := p.probeTarget(probeCtx, target)
+	p.recordProbeResult(ctx, target, ok)
+}
+
+func (p *ProxyServer) probeTarget(ctx context.Context, target string) bool {
+	if p.hcConfig.probeType == "http" {
+		return p.probeHTTP(ctx, target)
+	}
+	return p.probeTCP(ctx, target)
+}
+
+func (p *ProxyServer) probeTCP(ctx context.Context, target string) bool {
+	d := net.Dialer{Timeout: p.hcConfig.timeout}
+	conn, err := d.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func (p *ProxyServer) probeHTTP(ctx context.Context, target string) bool {
+	reqURL := fmt.Sprintf("http://%s%s", target, p.hcConfig.path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return false
+	}
+
+	client := http.Client{Timeout: p.hcConfig.timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	return resp.StatusCode == p.hcConfig.expectedStatus
+}
+
+// recordProbeResult folds one probe outcome into target's consecutive
+// success/failure counters, flips its healthy flag once the configured
+// threshold is crossed, and shares the result in Redis so other replicas
+// can use it for a target they have not probed yet.
+func (p *ProxyServer) recordProbeResult(ctx context.Context, target string, ok bool) {
+	val, _ := p.activeProbes.LoadOrStore(target, &activeProbeState{healthy: true})
+	st := val.(*activeProbeState)
+
+	st.mu.Lock()
+	if ok {
+		st.consecutiveFailure = 0
+		st.consecutiveSuccess++
+		if !st.healthy && st.consecutiveSuccess >= p.hcConfig.healthyThreshold {
+			st.healthy = true
+			slog.Info("Active health check: target is now healthy", "target_ip", target)
+		}
+	} else {
+		st.consecutiveSuccess = 0
+		st.consecutiveFailure++
+		if st.healthy && st.consecutiveFailure >= p.hcConfig.unhealthyThreshold {
+			st.healthy = false
+			slog.Warn("Active health check: target is now unhealthy", "target_ip", target)
+		}
+	}
+	healthy := st.healthy
+	st.mu.Unlock()
+
+	p.activeHealth.Store(target, healthy)
+
+	status := "unhealthy"
+	if healthy {
+		status = "healthy"
+	}
+	p.rdb.Set(ctx, healthRedisKey(target), status, p.hcConfig.interval*3)
+}
+
+// isActivelyHealthy reports whether target (an "ip:port" pair) is currently
+// passing its active health probe. It checks this replica's own probe
+// result first and falls back to the shared Redis state for a target only
+// another replica has probed so far.
+func (p *ProxyServer) isActivelyHealthy(ctx context.Context, target string) bool {
+	if !p.hcConfig.enabled {
+		return true
+	}
+
+	if v, ok := p.activeHealth.Load(target); ok {
+		return v.(bool)
+	}
+
+	if status, err := p.rdb.Get(ctx, healthRedisKey(target)).Result(); err == nil {
+		return status != "unhealthy"
+	}
+
+	// No data yet for this target: assume healthy until the prober catches
+	// up rather than excluding a brand-new target from the pool.
+	return true
+}
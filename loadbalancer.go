@@ -0,0 +1,412 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// LBStrategy names a pluggable load-balancing algorithm, selectable per
+// service via the proxy.lb-strategy ECS tag.
+type LBStrategy string
+
+const (
+	LBRoundRobin         LBStrategy = "round-robin"
+	LBWeightedRoundRobin LBStrategy = "weighted-round-robin"
+	LBLeastConnections   LBStrategy = "least-connections"
+	LBP2C                LBStrategy = "p2c"
+	LBConsistentHash     LBStrategy = "consistent-hash"
+)
+
+// releaseFunc is returned by LoadBalancer.Select and must be called once
+// the request to the chosen target has completed, so in-flight-tracking
+// strategies can decrement their counters.
+type releaseFunc func()
+
+func noopRelease() {}
+
+// availabilityFunc reports whether a target IP is currently allowed to
+// receive traffic per the circuit breaker, and whether doing so would be a
+// half-open probe. See circuitbreaker.go.
+type availabilityFunc func(ip string) (available, probing bool)
+
+// targetState is the per-target bookkeeping a LoadBalancer strategy needs:
+// its configured weight, smooth-WRR running weight, and live in-flight
+// request count.
+type targetState struct {
+	weight        int
+	currentWeight int
+	inFlight      int64
+
+	// activeRequests tracks in-flight requests independent of whichever
+	// LoadBalancer strategy the pool is using, so the per-target
+	// concurrency cap (see ratelimit.go) works the same way regardless of
+	// strategy.
+	activeRequests int64
+}
+
+// targetPool holds per-service load-balancing state, shared by whichever
+// LoadBalancer strategy the service is configured to use. One pool exists
+// per "cluster:service" key, independent of the ServiceInfo task cache.
+type targetPool struct {
+	mu        sync.Mutex
+	targets   map[string]*targetState
+	order     []string
+	rrCounter uint64
+}
+
+func newTargetPool() *targetPool {
+	return &targetPool{targets: make(map[string]*targetState)}
+}
+
+// sync reconciles the pool with the service's current task IPs and
+// configured weight, dropping targets that have scaled down or been
+// replaced.
+func (pool *targetPool) sync(tasks []string, weight int) {
+	if weight <= 0 {
+		weight = 1
+	}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	seen := make(map[string]bool, len(tasks))
+	for _, ip := range tasks {
+		seen[ip] = true
+		if ts, ok := pool.targets[ip]; ok {
+			ts.weight = weight
+			continue
+		}
+		pool.targets[ip] = &targetState{weight: weight}
+		pool.order = append(pool.order, ip)
+	}
+
+	filtered := pool.order[:0]
+	for _, ip := range pool.order {
+		if seen[ip] {
+			filtered = append(filtered, ip)
+		} else {
+			delete(pool.targets, ip)
+		}
+	}
+	pool.order = filtered
+}
+
+// snapshotOrder returns a copy of the pool's stable target order, safe to
+// range over without holding the pool lock.
+func (pool *targetPool) snapshotOrder() []string {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	order := make([]string, len(pool.order))
+	copy(order, pool.order)
+	return order
+}
+
+// get returns the targetState for ip, or nil if it has since been dropped
+// by a concurrent sync.
+func (pool *targetPool) get(ip string) *targetState {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	return pool.targets[ip]
+}
+
+// acquireTarget increments ip's active-request count for the per-target
+// concurrency cap and returns a release func to call once the request
+// completes. Safe to call alongside any LoadBalancer strategy.
+func (pool *targetPool) acquireTarget(ip string) releaseFunc {
+	pool.mu.Lock()
+	ts := pool.targets[ip]
+	pool.mu.Unlock()
+	if ts == nil {
+		return noopRelease
+	}
+	atomic.AddInt64(&ts.activeRequests, 1)
+	return func() { atomic.AddInt64(&ts.activeRequests, -1) }
+}
+
+// underTargetLimit reports whether ip is currently below max concurrent
+// requests. A non-positive max means the target has no cap.
+func (pool *targetPool) underTargetLimit(ip string, max int) bool {
+	if max <= 0 {
+		return true
+	}
+	pool.mu.Lock()
+	ts := pool.targets[ip]
+	pool.mu.Unlock()
+	if ts == nil {
+		return true
+	}
+	return atomic.LoadInt64(&ts.activeRequests) < int64(max)
+}
+
+// availableTarget is a pool target that has already passed the circuit
+// breaker's availability check.
+type availableTarget struct {
+	ip      string
+	probing bool
+}
+
+// collectAvailable filters order down to the targets currently allowed to
+// receive traffic, calling isAvailable exactly once per target so a
+// half-open probe transition only ever fires once.
+func collectAvailable(order []string, isAvailable availabilityFunc) []availableTarget {
+	var out []availableTarget
+	for _, ip := range order {
+		if ok, probing := isAvailable(ip); ok {
+			out = append(out, availableTarget{ip: ip, probing: probing})
+		}
+	}
+	return out
+}
+
+// LoadBalancer selects a target from a service's pool. Implementations are
+// stateless themselves; all mutable state lives in the targetPool so one
+// LoadBalancer instance can safely be shared across services.
+type LoadBalancer interface {
+	// Select returns the chosen target IP and a release func the caller
+	// must invoke once the request to it has completed. hashKey is only
+	// consulted by the consistent-hash strategy.
+	Select(pool *targetPool, isAvailable availabilityFunc, r *http.Request, hashKey string) (ip string, release releaseFunc, probing bool, ok bool)
+}
+
+var (
+	roundRobinBalancer         = &roundRobinLB{}
+	weightedRoundRobinBalancer = &weightedRoundRobinLB{}
+	leastConnectionsBalancer   = &leastConnectionsLB{}
+	p2cBalancer                = &p2cLB{}
+	consistentHashBalancer     = &consistentHashLB{}
+)
+
+// balancerFor resolves the configured per-service strategy name to a
+// LoadBalancer, defaulting to round-robin for an empty or unknown value.
+func balancerFor(strategy string) LoadBalancer {
+	switch LBStrategy(strategy) {
+	case LBWeightedRoundRobin:
+		return weightedRoundRobinBalancer
+	case LBLeastConnections:
+		return leastConnectionsBalancer
+	case LBP2C:
+		return p2cBalancer
+	case LBConsistentHash:
+		return consistentHashBalancer
+	default:
+		return roundRobinBalancer
+	}
+}
+
+// roundRobinLB cycles through available targets using a monotonic counter,
+// so fairness degrades gracefully as targets come and go rather than
+// relying on a single shared index into a slice that can resize underneath
+// it (the race the naive LastIndex-based rotation used to have).
+type roundRobinLB struct{}
+
+func (b *roundRobinLB) Select(pool *targetPool, isAvailable availabilityFunc, r *http.Request, hashKey string) (string, releaseFunc, bool, bool) {
+	avail := collectAvailable(pool.snapshotOrder(), isAvailable)
+	if len(avail) == 0 {
+		return "", noopRelease, false, false
+	}
+	idx := int(atomic.AddUint64(&pool.rrCounter, 1)-1) % len(avail)
+	t := avail[idx]
+	return t.ip, noopRelease, t.probing, true
+}
+
+// weightedRoundRobinLB implements nginx-style smooth weighted round-robin:
+// each target accumulates its weight every selection, and the target with
+// the highest running total is chosen and then discounted by the sum of
+// all weights. This spreads picks evenly rather than bursting through one
+// heavy target before moving to the next.
+type weightedRoundRobinLB struct{}
+
+func (b *weightedRoundRobinLB) Select(pool *targetPool, isAvailable availabilityFunc, r *http.Request, hashKey string) (string, releaseFunc, bool, bool) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	var bestIP string
+	var best *targetState
+	var bestProbing bool
+	total := 0
+
+	for _, ip := range pool.order {
+		ts := pool.targets[ip]
+		available, probing := isAvailable(ip)
+		if !available {
+			continue
+		}
+		ts.currentWeight += ts.weight
+		total += ts.weight
+		if best == nil || ts.currentWeight > best.currentWeight {
+			best = ts
+			bestIP = ip
+			bestProbing = probing
+		}
+	}
+
+	if best == nil {
+		return "", noopRelease, false, false
+	}
+	best.currentWeight -= total
+	return bestIP, noopRelease, bestProbing, true
+}
+
+// leastConnectionsLB sends each request to whichever available target
+// currently has the fewest in-flight requests, as tracked by the release
+// func ServeHTTP invokes when the upstream call completes.
+type leastConnectionsLB struct{}
+
+func (b *leastConnectionsLB) Select(pool *targetPool, isAvailable availabilityFunc, r *http.Request, hashKey string) (string, releaseFunc, bool, bool) {
+	pool.mu.Lock()
+	var bestIP string
+	var best *targetState
+	var bestProbing bool
+	for _, ip := range pool.order {
+		available, probing := isAvailable(ip)
+		if !available {
+			continue
+		}
+		ts := pool.targets[ip]
+		if best == nil || atomic.LoadInt64(&ts.inFlight) < atomic.LoadInt64(&best.inFlight) {
+			best = ts
+			bestIP = ip
+			bestProbing = probing
+		}
+	}
+	pool.mu.Unlock()
+
+	if best == nil {
+		return "", noopRelease, false, false
+	}
+
+	atomic.AddInt64(&best.inFlight, 1)
+	return bestIP, func() { atomic.AddInt64(&best.inFlight, -1) }, bestProbing, true
+}
+
+// p2cLB implements random-with-two-choices: it samples two available
+// targets at random and routes to the less loaded of the pair. This
+// approximates least-connections' balance while comparing the in-flight
+// counts of only two targets; every target is still checked for
+// availability first.
+type p2cLB struct{}
+
+func (b *p2cLB) Select(pool *targetPool, isAvailable availabilityFunc, r *http.Request, hashKey string) (string, releaseFunc, bool, bool) {
+	avail := collectAvailable(pool.snapshotOrder(), isAvailable)
+	if len(avail) == 0 {
+		return "", noopRelease, false, false
+	}
+	if len(avail) == 1 {
+		t := avail[0]
+		ts := pool.get(t.ip)
+		if ts == nil {
+			return "", noopRelease, false, false
+		}
+		atomic.AddInt64(&ts.inFlight, 1)
+		return t.ip, func() { atomic.AddInt64(&ts.inFlight, -1) }, t.probing, true
+	}
+
+	i := rand.Intn(len(avail))
+	j := rand.Intn(len(avail))
+	for j == i {
+		j = rand.Intn(len(avail))
+	}
+
+	chosen := avail[i]
+	tsChosen := pool.get(chosen.ip)
+	tsOther := pool.get(avail[j].ip)
+	if tsChosen == nil || tsOther == nil {
+		return "", noopRelease, false, false
+	}
+	if atomic.LoadInt64(&tsOther.inFlight) < atomic.LoadInt64(&tsChosen.inFlight) {
+		chosen = avail[j]
+		tsChosen = tsOther
+	}
+
+	atomic.AddInt64(&tsChosen.inFlight, 1)
+	return chosen.ip, func() { atomic.AddInt64(&tsChosen.inFlight, -1) }, chosen.probing, true
+}
+
+// stickyCookieName is the cookie a proxy.sticky=cookie route uses to pin a
+// client to the backend it was first routed to, for as long as that
+// backend stays available.
+const stickyCookieName = "ecsproxy_affinity"
+
+// stickyTarget returns the target IP pinned by r's affinity cookie, along
+// with whether selecting it counts as a half-open probe. ok is false if the
+// request carries no cookie, the cookie names a target the pool no longer
+// knows about, or that target isn't currently available — callers should
+// fall back to the route's configured LoadBalancer in that case.
+func stickyTarget(pool *targetPool, isAvailable availabilityFunc, r *http.Request) (ip string, probing bool, ok bool) {
+	cookie, err := r.Cookie(stickyCookieName)
+	if err != nil || cookie.Value == "" {
+		return "", false, false
+	}
+	if pool.get(cookie.Value) == nil {
+		return "", false, false
+	}
+	available, probing := isAvailable(cookie.Value)
+	if !available {
+		return "", false, false
+	}
+	return cookie.Value, probing, true
+}
+
+// setStickyCookie pins the client to targetIP for future requests, when the
+// route uses cookie-based stickiness. No-op otherwise. Uses Header().Set
+// rather than http.SetCookie's Add so a retry against a different target
+// overwrites rather than stacks an earlier attempt's cookie.
+func setStickyCookie(w http.ResponseWriter, rule *RouteRule, targetIP string) {
+	if rule.Sticky != "cookie" {
+		return
+	}
+	cookie := &http.Cookie{
+		Name:     stickyCookieName,
+		Value:    targetIP,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	w.Header().Set("Set-Cookie", cookie.String())
+}
+
+// consistentHashLB hashes a per-request key (typically a header value
+// configured via proxy.lb-hash-header) against every available target
+// using rendezvous (highest random weight) hashing, so requests sharing
+// that key land on the same backend as long as it stays healthy. Unlike
+// hashing the key modulo the target count, rendezvous hashing only remaps
+// the keys that were assigned to a target when it leaves the available
+// set - everyone else's pick is unaffected - which is what makes it fit to
+// call "consistent" for ECS autoscaling/rolling deploys that routinely
+// change the available target count.
+type consistentHashLB struct{}
+
+func (b *consistentHashLB) Select(pool *targetPool, isAvailable availabilityFunc, r *http.Request, hashKey string) (string, releaseFunc, bool, bool) {
+	avail := collectAvailable(pool.snapshotOrder(), isAvailable)
+	if len(avail) == 0 {
+		return "", noopRelease, false, false
+	}
+	if hashKey == "" {
+		// No affinity key on this request; fall back to the remote
+		// address so at least repeated requests on one connection land
+		// consistently.
+		hashKey = r.RemoteAddr
+	}
+
+	best := avail[0]
+	bestScore := rendezvousScore(hashKey, best.ip)
+	for _, t := range avail[1:] {
+		if score := rendezvousScore(hashKey, t.ip); score > bestScore {
+			best, bestScore = t, score
+		}
+	}
+	return best.ip, noopRelease, best.probing, true
+}
+
+// rendezvousScore computes key's score against candidate for rendezvous
+// hashing: the candidate with the highest score for a given key wins.
+func rendezvousScore(key, candidate string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	h.Write([]byte{':'})
+	h.Write([]byte(candidate))
+	return h.Sum32()
+}
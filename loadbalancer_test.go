@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func alwaysAvailable(string) (bool, bool) { return true, false }
+
+func poolWithTargets(ips ...string) *targetPool {
+	pool := newTargetPool()
+	pool.sync(ips, 1)
+	return pool
+}
+
+func TestCollectAvailable(t *testing.T) {
+	order := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	unavailable := map[string]bool{"10.0.0.2": true}
+
+	isAvailable := func(ip string) (bool, bool) {
+		return !unavailable[ip], false
+	}
+
+	got := collectAvailable(order, isAvailable)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 available targets, got %d: %+v", len(got), got)
+	}
+	if got[0].ip != "10.0.0.1" || got[1].ip != "10.0.0.3" {
+		t.Fatalf("unexpected available targets: %+v", got)
+	}
+}
+
+func TestCollectAvailable_CallsIsAvailableOnceEach(t *testing.T) {
+	order := []string{"10.0.0.1", "10.0.0.2"}
+	calls := make(map[string]int)
+	isAvailable := func(ip string) (bool, bool) {
+		calls[ip]++
+		return true, false
+	}
+
+	collectAvailable(order, isAvailable)
+
+	for ip, n := range calls {
+		if n != 1 {
+			t.Errorf("isAvailable called %d times for %s, want 1", n, ip)
+		}
+	}
+}
+
+func TestRoundRobinLB_CyclesAllAvailableTargets(t *testing.T) {
+	pool := poolWithTargets("10.0.0.1", "10.0.0.2", "10.0.0.3")
+	r := &http.Request{}
+
+	seen := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		ip, _, _, ok := roundRobinBalancer.Select(pool, alwaysAvailable, r, "")
+		if !ok {
+			t.Fatalf("Select() returned ok=false")
+		}
+		seen[ip]++
+	}
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		if seen[ip] != 2 {
+			t.Errorf("target %s selected %d times over 6 picks, want 2", ip, seen[ip])
+		}
+	}
+}
+
+func TestRoundRobinLB_NoAvailableTargets(t *testing.T) {
+	pool := poolWithTargets("10.0.0.1")
+	r := &http.Request{}
+
+	_, _, _, ok := roundRobinBalancer.Select(pool, func(string) (bool, bool) { return false, false }, r, "")
+	if ok {
+		t.Fatal("Select() returned ok=true with no available targets")
+	}
+}
+
+func TestConsistentHashLB_SameKeySameTarget(t *testing.T) {
+	pool := poolWithTargets("10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4")
+	r := &http.Request{}
+
+	first, _, _, ok := consistentHashBalancer.Select(pool, alwaysAvailable, r, "user-42")
+	if !ok {
+		t.Fatalf("Select() returned ok=false")
+	}
+	for i := 0; i < 10; i++ {
+		ip, _, _, ok := consistentHashBalancer.Select(pool, alwaysAvailable, r, "user-42")
+		if !ok || ip != first {
+			t.Fatalf("Select() = %s, ok=%v on repeat call, want %s", ip, ok, first)
+		}
+	}
+}
+
+// TestConsistentHashLB_MinimalRemap verifies the property that makes
+// rendezvous hashing "consistent": removing one target from the available
+// set only remaps the keys that were assigned to it, and leaves every
+// other key's chosen target unchanged.
+func TestConsistentHashLB_MinimalRemap(t *testing.T) {
+	targets := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5"}
+	before := poolWithTargets(targets...)
+	r := &http.Request{}
+
+	keys := make([]string, 200)
+	assignment := make(map[string]string, len(keys))
+	for i := range keys {
+		keys[i] = fmt.Sprintf("client-%d", i)
+		ip, _, _, ok := consistentHashBalancer.Select(before, alwaysAvailable, r, keys[i])
+		if !ok {
+			t.Fatalf("Select() returned ok=false for key %s", keys[i])
+		}
+		assignment[keys[i]] = ip
+	}
+
+	removed := targets[0]
+	after := poolWithTargets(targets[1:]...)
+
+	for _, key := range keys {
+		ip, _, _, ok := consistentHashBalancer.Select(after, alwaysAvailable, r, key)
+		if !ok {
+			t.Fatalf("Select() returned ok=false for key %s after removing a target", key)
+		}
+		if assignment[key] != removed && ip != assignment[key] {
+			t.Errorf("key %s remapped from %s to %s after an unrelated target was removed", key, assignment[key], ip)
+		}
+	}
+}
+
+func TestLeastConnectionsLB_PrefersFewerInFlight(t *testing.T) {
+	pool := poolWithTargets("10.0.0.1", "10.0.0.2")
+	r := &http.Request{}
+
+	// Occupy 10.0.0.1 with an in-flight request so 10.0.0.2 should win.
+	_, release, _, ok := leastConnectionsBalancer.Select(pool, alwaysAvailable, r, "")
+	if !ok {
+		t.Fatalf("Select() returned ok=false")
+	}
+	defer release()
+
+	ip, release2, _, ok := leastConnectionsBalancer.Select(pool, alwaysAvailable, r, "")
+	if !ok {
+		t.Fatalf("Select() returned ok=false")
+	}
+	defer release2()
+	if ip != "10.0.0.2" {
+		t.Errorf("Select() = %s, want 10.0.0.2 (the target with fewer in-flight requests)", ip)
+	}
+}
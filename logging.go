@@ -0,0 +1,27 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+)
+
+// initLogging installs a JSON slog logger as the process-wide default, which
+// every log line in the proxy goes through.
+func initLogging() {
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+	})
+	slog.SetDefault(slog.New(handler))
+}
+
+// newRequestID returns a short random hex identifier used to correlate a
+// single proxied request across its log lines and trace spans.
+func newRequestID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b[:])
+}
@@ -1,13 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -16,20 +18,71 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 type ProxyServer struct {
 	rdb           *redis.Client
 	ecsClient     *ecs.Client
 	ec2Client     *ec2.Client
+	sqsClient     *sqs.Client
 	loadBalancer  *sync.Map
 	cacheDuration time.Duration
+
+	// targetHealth tracks circuit-breaker state per backend, keyed by
+	// "ip:port". See circuitbreaker.go.
+	targetHealth *sync.Map
+	cbConfig     circuitBreakerConfig
+
+	// router resolves incoming requests to an ECS service by matching their
+	// Host and path against rules built from ECS service tags. See router.go.
+	router       *Router
+	routerConfig routerConfig
+
+	// lbPools holds one *targetPool per "cluster:service" key, tracking
+	// weights and in-flight counts for the pluggable LoadBalancer
+	// strategies. See loadbalancer.go.
+	lbPools *sync.Map
+
+	// activeHealth and activeProbes back the independent active
+	// health-checking subsystem: activeHealth is the externally-visible
+	// healthy/unhealthy flag per "ip:port", activeProbes holds each
+	// target's local consecutive-result counters. See healthcheck.go.
+	activeHealth    *sync.Map
+	activeProbes    *sync.Map
+	hcConfig        healthCheckConfig
+	ecsEventsConfig ecsEventSubscriberConfig
+
+	// concurrency enforces the global in-flight request cap. See
+	// ratelimit.go.
+	concurrency *globalConcurrency
+	// trustedProxies gates which peers' X-Forwarded-For header is honored
+	// when deriving a client's rate-limit key. See ratelimit.go.
+	trustedProxies trustedProxies
 }
 
 type ServiceInfo struct {
-	Tasks     []string
-	LastIndex int
+	Tasks []string
+}
+
+// taskCacheKey returns the Redis key a service's task list is cached under.
+// Shared by getServiceTasks and invalidateServiceCache (ecsevents.go) so
+// the two can never drift apart and silently stop invalidating each other.
+func taskCacheKey(serviceName, cluster string) string {
+	return fmt.Sprintf("service:%s:cluster:%s:tasks", serviceName, cluster)
+}
+
+// poolCacheKey returns the key a service's in-memory task list and
+// load-balancing pool are stored under in ProxyServer.loadBalancer and
+// lbPools. Shared for the same reason as taskCacheKey.
+func poolCacheKey(cluster, serviceName string) string {
+	return fmt.Sprintf("%s:%s", cluster, serviceName)
 }
 
 func NewProxyServer(redisAddr string) (*ProxyServer, error) {
@@ -48,22 +101,49 @@ func NewProxyServer(redisAddr string) (*ProxyServer, error) {
 		return nil, fmt.Errorf("unable to load AWS config: %v", err)
 	}
 
-	return &ProxyServer{
-		rdb:           rdb,
-		ecsClient:     ecs.NewFromConfig(cfg),
-		ec2Client:     ec2.NewFromConfig(cfg),
-		loadBalancer:  &sync.Map{},
-		cacheDuration: 30 * time.Second,
-	}, nil
+	p := &ProxyServer{
+		rdb:             rdb,
+		ecsClient:       ecs.NewFromConfig(cfg),
+		ec2Client:       ec2.NewFromConfig(cfg),
+		sqsClient:       sqs.NewFromConfig(cfg),
+		loadBalancer:    &sync.Map{},
+		cacheDuration:   30 * time.Second,
+		targetHealth:    &sync.Map{},
+		cbConfig:        loadCircuitBreakerConfig(),
+		router:          NewRouter(),
+		routerConfig:    loadRouterConfig(),
+		lbPools:         &sync.Map{},
+		activeHealth:    &sync.Map{},
+		activeProbes:    &sync.Map{},
+		hcConfig:        loadHealthCheckConfig(),
+		ecsEventsConfig: loadECSEventSubscriberConfig(),
+		concurrency:     loadGlobalConcurrency(),
+		trustedProxies:  loadTrustedProxies(),
+	}
+
+	// Populate the router from Redis or ECS so the first request doesn't
+	// 404 while the background refresh loop is still starting up.
+	if rules, err := p.loadRoutes(context.Background()); err != nil {
+		slog.Error("Initial route discovery failed, starting with an empty route table", "error", err)
+	} else {
+		p.router.setRules(rules)
+	}
+
+	return p, nil
 }
 
 func (p *ProxyServer) getPublicIPFromENI(ctx context.Context, eniID string) (string, error) {
+	ctx, span := tracer().Start(ctx, "ec2.DescribeNetworkInterfaces", trace.WithAttributes(attribute.String("eni_id", eniID)))
+	defer span.End()
+
 	input := &ec2.DescribeNetworkInterfacesInput{
 		NetworkInterfaceIds: []string{eniID},
 	}
 
 	result, err := p.ec2Client.DescribeNetworkInterfaces(ctx, input)
+	recordAWSCall("DescribeNetworkInterfaces", err)
 	if err != nil {
+		span.RecordError(err)
 		return "", fmt.Errorf("failed to describe network interface: %v", err)
 	}
 
@@ -75,14 +155,25 @@ func (p *ProxyServer) getPublicIPFromENI(ctx context.Context, eniID string) (str
 }
 
 func (p *ProxyServer) getServiceTasks(ctx context.Context, serviceName, cluster string) (*ServiceInfo, error) {
+	ctx, span := tracer().Start(ctx, "ecs.getServiceTasks", trace.WithAttributes(
+		attribute.String("service", serviceName),
+		attribute.String("cluster", cluster),
+	))
+	defer span.End()
+
+	log := slog.With("service", serviceName, "cluster", cluster)
+
 	// Try to get from Redis first
-	cacheKey := fmt.Sprintf("service:%s:cluster:%s:tasks", serviceName, cluster)
+	cacheKey := taskCacheKey(serviceName, cluster)
 	if cached, err := p.rdb.Get(ctx, cacheKey).Result(); err == nil {
 		var serviceInfo ServiceInfo
 		if err := json.Unmarshal([]byte(cached), &serviceInfo); err == nil {
+			taskCacheRequests.WithLabelValues(serviceName, "hit").Inc()
+			targetPoolSize.WithLabelValues(serviceName).Set(float64(len(serviceInfo.Tasks)))
 			return &serviceInfo, nil
 		}
 	}
+	taskCacheRequests.WithLabelValues(serviceName, "miss").Inc()
 
 	// If not in cache, fetch from ECS
 	input := &ecs.ListTasksInput{
@@ -90,13 +181,22 @@ func (p *ProxyServer) getServiceTasks(ctx context.Context, serviceName, cluster
 		ServiceName: &serviceName,
 	}
 
-	tasks, err := p.ecsClient.ListTasks(ctx, input)
+	tasks, err := func() (*ecs.ListTasksOutput, error) {
+		ctx, span := tracer().Start(ctx, "ecs.ListTasks")
+		defer span.End()
+		out, err := p.ecsClient.ListTasks(ctx, input)
+		recordAWSCall("ListTasks", err)
+		if err != nil {
+			span.RecordError(err)
+		}
+		return out, err
+	}()
 	if err != nil {
-		log.Printf("ListTasks error: %v", err)
+		log.Error("ListTasks failed", "error", err)
 		return nil, fmt.Errorf("failed to list tasks: %v", err)
 	}
 
-	log.Printf("Found %d tasks", len(tasks.TaskArns))
+	log.Info("Listed tasks", "count", len(tasks.TaskArns))
 
 	if len(tasks.TaskArns) == 0 {
 		return nil, fmt.Errorf("no tasks found for service %s in cluster %s", serviceName, cluster)
@@ -108,17 +208,20 @@ func (p *ProxyServer) getServiceTasks(ctx context.Context, serviceName, cluster
 		Tasks:   tasks.TaskArns,
 	}
 
-	taskDetails, err := p.ecsClient.DescribeTasks(ctx, describeInput)
+	taskDetails, err := func() (*ecs.DescribeTasksOutput, error) {
+		ctx, span := tracer().Start(ctx, "ecs.DescribeTasks")
+		defer span.End()
+		out, err := p.ecsClient.DescribeTasks(ctx, describeInput)
+		recordAWSCall("DescribeTasks", err)
+		if err != nil {
+			span.RecordError(err)
+		}
+		return out, err
+	}()
 	if err != nil {
 		return nil, fmt.Errorf("failed to describe tasks: %v", err)
 	}
 
-	// Add this debugging code temporarily
-	for _, task := range taskDetails.Tasks {
-		taskJSON, _ := json.MarshalIndent(task, "", "    ")
-		log.Printf("Task details: %s", string(taskJSON))
-	}
-
 	// Extract public IPs from attachments
 	var taskIPs []string
 	for _, task := range taskDetails.Tasks {
@@ -140,10 +243,10 @@ func (p *ProxyServer) getServiceTasks(ctx context.Context, serviceName, cluster
 				// Get public IP using EC2 API
 				if publicIP, err := p.getPublicIPFromENI(ctx, eniID); err == nil {
 					taskIPs = append(taskIPs, publicIP)
-					log.Printf("Added public IP: %s for ENI: %s", publicIP, eniID)
+					log.Info("Resolved public IP", "public_ip", publicIP, "eni_id", eniID)
 					continue
 				} else {
-					log.Printf("Failed to get public IP for ENI %s: %v", eniID, err)
+					log.Warn("Failed to resolve public IP for ENI", "eni_id", eniID, "error", err)
 				}
 			}
 
@@ -152,7 +255,7 @@ func (p *ProxyServer) getServiceTasks(ctx context.Context, serviceName, cluster
 				for _, ni := range container.NetworkInterfaces {
 					if ni.PrivateIpv4Address != nil {
 						taskIPs = append(taskIPs, *ni.PrivateIpv4Address)
-						log.Printf("Added private IP (fallback): %s for task: %s", *ni.PrivateIpv4Address, *task.TaskArn)
+						log.Info("Falling back to private IP", "private_ip", *ni.PrivateIpv4Address, "task_arn", *task.TaskArn)
 					}
 				}
 			}
@@ -163,9 +266,10 @@ func (p *ProxyServer) getServiceTasks(ctx context.Context, serviceName, cluster
 		return nil, fmt.Errorf("no IPs found for running tasks in service %s", serviceName)
 	}
 
+	targetPoolSize.WithLabelValues(serviceName).Set(float64(len(taskIPs)))
+
 	serviceInfo := &ServiceInfo{
-		Tasks:     taskIPs,
-		LastIndex: 0,
+		Tasks: taskIPs,
 	}
 
 	// Cache the result
@@ -176,14 +280,13 @@ func (p *ProxyServer) getServiceTasks(ctx context.Context, serviceName, cluster
 	return serviceInfo, nil
 }
 
-func (p *ProxyServer) getNextTarget(serviceName, cluster string) (*ServiceInfo, error) {
-	cacheKey := fmt.Sprintf("%s:%s", cluster, serviceName)
+func (p *ProxyServer) getNextTarget(ctx context.Context, serviceName, cluster string) (*ServiceInfo, error) {
+	cacheKey := poolCacheKey(cluster, serviceName)
 	val, _ := p.loadBalancer.LoadOrStore(cacheKey, &ServiceInfo{})
 	serviceInfo := val.(*ServiceInfo)
 
 	if len(serviceInfo.Tasks) == 0 {
 		// Fetch new tasks
-		ctx := context.Background()
 		newInfo, err := p.getServiceTasks(ctx, serviceName, cluster)
 		if err != nil {
 			return nil, err
@@ -195,69 +298,323 @@ func (p *ProxyServer) getNextTarget(serviceName, cluster string) (*ServiceInfo,
 	return serviceInfo, nil
 }
 
+// poolFor returns the load-balancing pool for a "cluster:service" key,
+// creating it on first use.
+func (p *ProxyServer) poolFor(cacheKey string) *targetPool {
+	val, _ := p.lbPools.LoadOrStore(cacheKey, newTargetPool())
+	return val.(*targetPool)
+}
+
 func (p *ProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Parse domain parts
-	parts := strings.Split(r.Host, ".")
-	if len(parts) < 6 {
-		log.Printf("Invalid domain format. Got %d parts, expected at least 6", len(parts))
-		http.Error(w, "Invalid domain format", http.StatusBadRequest)
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer().Start(ctx, "proxy.ServeHTTP", trace.WithAttributes(
+		attribute.String("http.host", r.Host),
+		attribute.String("http.method", r.Method),
+		attribute.String("http.path", r.URL.Path),
+	))
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	requestID := newRequestID()
+	start := time.Now()
+
+	rule, ok := ruleFromContext(r.Context())
+	if !ok {
+		rule, ok = p.router.match(r.Host, r.URL.Path)
+	}
+	if !ok {
+		slog.Warn("No route for request", "request_id", requestID, "host", r.Host, "path", r.URL.Path)
+		http.Error(w, "No matching route", http.StatusNotFound)
+		return
+	}
+
+	serviceName := rule.ServiceName
+	port := rule.Port
+	cluster := rule.Cluster
+
+	log := slog.With("request_id", requestID, "service", serviceName, "cluster", cluster)
+	log.Info("Matched route", "host", rule.Host, "port", port)
+
+	// Protocol-upgrade requests (WebSocket handshakes) need the real
+	// ResponseWriter passed straight through to httputil.ReverseProxy so it
+	// can type-assert it to http.Hijacker; bufferedResponse doesn't
+	// implement Hijacker, and a half-completed handshake can't be retried
+	// against a different target anyway.
+	if isUpgradeRequest(r) {
+		p.serveUpgrade(w, r, rule, requestID, start)
+		return
+	}
+
+	// Only idempotent methods get retried across targets; a retry on a
+	// partially-applied POST could double the side effect.
+	maxAttempts := 1
+	if isIdempotentMethod(r.Method) {
+		maxAttempts += p.cbConfig.maxRetries
+	}
+
+	// Buffer the body once so it can be replayed against a different
+	// target on retry.
+	var bodyBytes []byte
+	if r.Body != nil {
+		bodyBytes, _ = io.ReadAll(r.Body)
+		r.Body.Close()
+	}
+
+	cacheKey := poolCacheKey(cluster, serviceName)
+	balancer := balancerFor(rule.LBStrategy)
+	hashKey := ""
+	if rule.HashHeader != "" {
+		hashKey = r.Header.Get(rule.HashHeader)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		// Re-invoke target selection on every attempt so a retry lands on
+		// a fresh, healthy backend rather than the one that just failed.
+		serviceInfo, err := p.getNextTarget(ctx, serviceName, cluster)
+		if err != nil {
+			lastErr = err
+			break
+		}
+
+		pool := p.poolFor(cacheKey)
+		pool.sync(serviceInfo.Tasks, rule.Weight)
+
+		available := func(ip string) (bool, bool) {
+			target := targetHealthKey(ip, port)
+			if !p.isActivelyHealthy(r.Context(), target) {
+				return false, false
+			}
+			if !pool.underTargetLimit(ip, rule.MaxTargetConcurrency) {
+				concurrencyRejections.WithLabelValues("target").Inc()
+				return false, false
+			}
+			return p.isTargetAvailable(target)
+		}
+
+		var targetIP string
+		var release releaseFunc
+		var probing, selected bool
+		if rule.Sticky == "cookie" {
+			targetIP, probing, selected = stickyTarget(pool, available, r)
+			release = noopRelease
+		}
+		if !selected {
+			targetIP, release, probing, selected = balancer.Select(pool, available, r, hashKey)
+		}
+		if !selected {
+			lastErr = fmt.Errorf("no healthy targets for service %s in cluster %s", serviceName, cluster)
+			break
+		}
+		key := targetHealthKey(targetIP, port)
+		if probing {
+			log.Info("Half-open probe", "target_ip", targetIP)
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+
+		// Set before proxying (and overwritten, not appended, on retry) so
+		// it's already on w's header map however the response ends up being
+		// written - buffered-and-flushed or committed to streaming partway
+		// through.
+		setStickyCookie(w, rule, targetIP)
+
+		targetRelease := pool.acquireTarget(targetIP)
+		attemptStart := time.Now()
+		resp, proxyErr := p.proxyRequest(ctx, w, targetIP, port, r)
+		release()
+		targetRelease()
+		upstreamLatency.WithLabelValues(serviceName).Observe(time.Since(attemptStart).Seconds())
+
+		if proxyErr != nil {
+			log.Error("Upstream request failed", "target_ip", targetIP, "error", proxyErr)
+			requestsTotal.WithLabelValues(serviceName, "error").Inc()
+			p.recordTargetFailure(key)
+			lastErr = proxyErr
+			continue
+		}
+
+		if resp.streaming {
+			// Already committed to the real client; nothing left to
+			// retry or flush, just record the outcome.
+			requestsTotal.WithLabelValues(serviceName, strconv.Itoa(resp.statusCode)).Inc()
+			if resp.statusCode >= http.StatusInternalServerError {
+				p.recordTargetFailure(key)
+			} else {
+				p.recordTargetSuccess(key)
+			}
+			log.Info("Request completed (streamed)", "target_ip", targetIP, "status", resp.statusCode, "duration", time.Since(start).String())
+			return
+		}
+
+		requestsTotal.WithLabelValues(serviceName, strconv.Itoa(resp.statusCode)).Inc()
+
+		if p.cbConfig.retryableStatus[resp.statusCode] {
+			lastErr = fmt.Errorf("upstream %s returned retryable status %d", key, resp.statusCode)
+			p.recordTargetFailure(key)
+			if attempt < maxAttempts-1 {
+				log.Warn("Retryable upstream status, retrying", "target_ip", targetIP, "status", resp.statusCode)
+				continue
+			}
+		} else if resp.statusCode >= http.StatusInternalServerError {
+			p.recordTargetFailure(key)
+		} else {
+			p.recordTargetSuccess(key)
+		}
+
+		log.Info("Request completed", "target_ip", targetIP, "status", resp.statusCode, "duration", time.Since(start).String())
+		resp.flush(w)
 		return
 	}
 
-	serviceName := parts[0]
-	port := parts[1]
-	cluster := parts[2]
+	log.Error("Giving up on service", "error", lastErr, "duration", time.Since(start).String())
+	http.Error(w, "Service not available", http.StatusServiceUnavailable)
+}
+
+// proxyRequest sends r to targetIP:port through a fresh reverse proxy and
+// buffers the response so the caller can decide whether to retry before
+// anything reaches the real client, up to maxBufferedResponseBody — past
+// that it streams straight to w instead (see bufferedResponse).
+func (p *ProxyServer) proxyRequest(ctx context.Context, w http.ResponseWriter, targetIP, port string, r *http.Request) (*bufferedResponse, error) {
+	_, span := tracer().Start(ctx, "upstream.request", trace.WithAttributes(
+		attribute.String("target_ip", targetIP),
+		attribute.String("target_port", port),
+	))
+	defer span.End()
+
+	target := fmt.Sprintf("http://%s:%s", targetIP, port)
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target URL: %v", err)
+	}
+
+	proxy := newReverseProxy(targetURL)
+
+	var proxyErr error
+	proxy.ErrorHandler = func(_ http.ResponseWriter, _ *http.Request, err error) {
+		proxyErr = err
+	}
+
+	resp := newBufferedResponse(w)
+	proxy.ServeHTTP(resp, r)
+	if proxyErr != nil {
+		span.RecordError(proxyErr)
+		return nil, proxyErr
+	}
+	return resp, nil
+}
 
-	log.Printf("Parsed request - Service: %s, Port: %s, Cluster: %s", serviceName, port, cluster)
+// serveUpgrade proxies a protocol-upgrade request (e.g. a WebSocket
+// handshake) directly to the real ResponseWriter, bypassing the buffered
+// retry path entirely: httputil.ReverseProxy requires the ResponseWriter it
+// hijacks to implement http.Hijacker, which bufferedResponse doesn't, and a
+// half-completed handshake isn't safe to retry against a different target
+// anyway. Only a single target attempt is made.
+func (p *ProxyServer) serveUpgrade(w http.ResponseWriter, r *http.Request, rule *RouteRule, requestID string, start time.Time) {
+	serviceName := rule.ServiceName
+	port := rule.Port
+	cluster := rule.Cluster
+	log := slog.With("request_id", requestID, "service", serviceName, "cluster", cluster)
+
+	cacheKey := poolCacheKey(cluster, serviceName)
+	balancer := balancerFor(rule.LBStrategy)
+	hashKey := ""
+	if rule.HashHeader != "" {
+		hashKey = r.Header.Get(rule.HashHeader)
+	}
 
-	// Get target using round-robin
-	serviceInfo, err := p.getNextTarget(serviceName, cluster)
-	log.Println(err)
+	serviceInfo, err := p.getNextTarget(r.Context(), serviceName, cluster)
 	if err != nil {
+		log.Error("Giving up on upgrade request", "error", err, "duration", time.Since(start).String())
+		http.Error(w, "Service not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	pool := p.poolFor(cacheKey)
+	pool.sync(serviceInfo.Tasks, rule.Weight)
+
+	available := func(ip string) (bool, bool) {
+		target := targetHealthKey(ip, port)
+		if !p.isActivelyHealthy(r.Context(), target) {
+			return false, false
+		}
+		if !pool.underTargetLimit(ip, rule.MaxTargetConcurrency) {
+			concurrencyRejections.WithLabelValues("target").Inc()
+			return false, false
+		}
+		return p.isTargetAvailable(target)
+	}
+
+	targetIP, release, probing, ok := balancer.Select(pool, available, r, hashKey)
+	if !ok {
+		log.Error("Giving up on upgrade request", "error", fmt.Errorf("no healthy targets for service %s in cluster %s", serviceName, cluster), "duration", time.Since(start).String())
 		http.Error(w, "Service not available", http.StatusServiceUnavailable)
 		return
 	}
+	key := targetHealthKey(targetIP, port)
+	if probing {
+		log.Info("Half-open probe", "target_ip", targetIP)
+	}
 
-	// Round-robin selection
-	currentIndex := serviceInfo.LastIndex
-	serviceInfo.LastIndex = (currentIndex + 1) % len(serviceInfo.Tasks)
-	targetIP := serviceInfo.Tasks[currentIndex]
+	targetRelease := pool.acquireTarget(targetIP)
+	defer release()
+	defer targetRelease()
 
-	// Create target URL
 	target := fmt.Sprintf("http://%s:%s", targetIP, port)
 	targetURL, err := url.Parse(target)
 	if err != nil {
-		http.Error(w, "Invalid target URL", http.StatusInternalServerError)
+		p.recordTargetFailure(key)
+		log.Error("Invalid target URL", "target_ip", targetIP, "error", err)
+		http.Error(w, "Service not available", http.StatusServiceUnavailable)
 		return
 	}
 
-	// Create reverse proxy
-	proxy := httputil.NewSingleHostReverseProxy(targetURL)
+	proxy := newReverseProxy(targetURL)
+	var proxyErr error
+	proxy.ErrorHandler = func(rw http.ResponseWriter, _ *http.Request, err error) {
+		proxyErr = err
+		http.Error(rw, "Bad gateway", http.StatusBadGateway)
+	}
 	proxy.ServeHTTP(w, r)
+
+	if proxyErr != nil {
+		p.recordTargetFailure(key)
+		log.Error("Upgrade request failed", "target_ip", targetIP, "error", proxyErr)
+		return
+	}
+	p.recordTargetSuccess(key)
+	log.Info("Upgrade request completed", "target_ip", targetIP, "duration", time.Since(start).String())
 }
 
 func main() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	log.Printf("Starting proxy server...")
+	initLogging()
+	slog.Info("Starting proxy server...")
+
+	shutdownTracing := initTracing(context.Background())
+	defer shutdownTracing(context.Background())
 
 	karmaConfig := c.DefaultConfig()
-	log.Printf("Redis URL: %s", karmaConfig.RedisURL)
+	slog.Info("Loaded config", "redis_url", karmaConfig.RedisURL)
 
 	proxy, err := NewProxyServer(karmaConfig.RedisURL)
 	if err != nil {
-		log.Fatalf("Failed to create proxy server: %v", err)
+		slog.Error("Failed to create proxy server", "error", err)
+		panic(err)
 	}
 
-	// Refresh task cache periodically
+	// Refresh the route table and task cache periodically
 	go func() {
 		ticker := time.NewTicker(30 * time.Second)
 		for range ticker.C {
+			ctx := context.Background()
+
+			proxy.refreshRoutes(ctx)
+
 			proxy.loadBalancer.Range(func(key, value interface{}) bool {
 				cacheKey := key.(string)
 				parts := strings.Split(cacheKey, ":")
 				cluster, serviceName := parts[0], parts[1]
 
-				ctx := context.Background()
 				if info, err := proxy.getServiceTasks(ctx, serviceName, cluster); err == nil {
 					proxy.loadBalancer.Store(cacheKey, info)
 				}
@@ -266,11 +623,31 @@ func main() {
 		}
 	}()
 
+	// Active health checking and the ECS event subscriber run independently
+	// of the 30s cache refresh above; both are no-ops unless explicitly
+	// enabled via environment variables.
+	go proxy.runActiveHealthChecks(context.Background())
+	go proxy.runECSEventSubscriber(context.Background())
+
+	// The admin listener (metrics) is separate from the proxy's traffic
+	// port so scraping never competes with proxied requests.
+	go startAdminServer(adminAddr())
+
+	// TLS termination is opt-in; both the HTTPS listener and the HTTP
+	// redirect/ACME-challenge listener are no-ops unless configured.
+	tlsSettings := loadTLSSettings()
+	var acmeManager *autocert.Manager
+	if tlsSettings.acmeEnabled {
+		acmeManager = proxy.newACMEManager(tlsSettings)
+	}
+	go proxy.runTLSServer(tlsSettings, acmeManager)
+	go proxy.runRedirectServer(tlsSettings, acmeManager)
+
 	port := ":6969"
 
 	server := &http.Server{
 		Addr:    port,
-		Handler: proxy,
+		Handler: proxy.Handler(),
 		// Timeouts to prevent slow clients from holding connections
 		ReadTimeout:    10 * time.Second,
 		WriteTimeout:   10 * time.Second,
@@ -278,7 +655,9 @@ func main() {
 		MaxHeaderBytes: 1 << 20, // 1MB
 	}
 
-	log.Println("Server up and running on", port)
+	slog.Info("Server up and running", "addr", port)
 
-	log.Fatal(server.ListenAndServe())
+	if err := server.ListenAndServe(); err != nil {
+		slog.Error("Server stopped", "error", err)
+	}
 }
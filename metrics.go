@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus instruments. Labels are kept low-cardinality (service name,
+// coarse status) - target IPs churn as ECS tasks cycle, so they're never
+// used as a label value; per-target detail belongs in logs/traces instead.
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_requests_total",
+		Help: "Total proxied requests, by service and outcome.",
+	}, []string{"service", "status"})
+
+	upstreamLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "proxy_upstream_request_duration_seconds",
+		Help:    "Latency of a single upstream attempt, by service.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"service"})
+
+	taskCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_task_cache_requests_total",
+		Help: "Redis task-list cache lookups, by service and hit/miss.",
+	}, []string{"service", "result"})
+
+	targetPoolSize = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "proxy_target_pool_size",
+		Help: "Number of known backend targets for a service.",
+	}, []string{"service"})
+
+	awsAPICalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_aws_api_calls_total",
+		Help: "AWS API calls made while resolving routes and targets.",
+	}, []string{"operation", "result"})
+
+	rateLimitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_rate_limit_rejections_total",
+		Help: "Requests rejected by the per-route token-bucket rate limiter.",
+	}, []string{"service", "key_source"})
+
+	concurrencyRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_concurrency_rejections_total",
+		Help: "Requests shed due to a concurrency limit, by scope (global or target).",
+	}, []string{"scope"})
+)
+
+// recordAWSCall records the outcome of one AWS SDK call for the
+// proxy_aws_api_calls_total metric.
+func recordAWSCall(operation string, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	awsAPICalls.WithLabelValues(operation, result).Inc()
+}
+
+// adminAddr returns the listen address for the admin HTTP server (metrics),
+// separate from the proxy's traffic port so scraping never
+// competes with proxied requests.
+func adminAddr() string {
+	if v := os.Getenv("PROXY_ADMIN_ADDR"); v != "" {
+		return v
+	}
+	return ":9090"
+}
+
+// startAdminServer serves /metrics for Prometheus scraping. It runs on its
+// own listener and is expected to be started in its own goroutine.
+func startAdminServer(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	slog.Info("Admin server listening", "addr", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		slog.Error("Admin server stopped", "error", err)
+	}
+}
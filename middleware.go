@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+type ctxKey int
+
+// ctxKeyRule is the context key routingMiddleware stores the matched
+// RouteRule under, so downstream middleware and ServeHTTP itself don't each
+// need to re-match the request.
+const ctxKeyRule ctxKey = iota
+
+func ruleFromContext(ctx context.Context) (*RouteRule, bool) {
+	rule, ok := ctx.Value(ctxKeyRule).(*RouteRule)
+	return rule, ok
+}
+
+// Handler returns the full middleware chain in front of ServeHTTP: routing,
+// then global concurrency shedding, then per-route rate limiting.
+func (p *ProxyServer) Handler() http.Handler {
+	return p.routingMiddleware(p.concurrencyMiddleware(p.rateLimitMiddleware(http.HandlerFunc(p.ServeHTTP))))
+}
+
+// routingMiddleware resolves the request's RouteRule once and stashes it in
+// the request context.
+func (p *ProxyServer) routingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rule, ok := p.router.match(r.Host, r.URL.Path); ok {
+			r = r.WithContext(context.WithValue(r.Context(), ctxKeyRule, rule))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// concurrencyMiddleware sheds load once this replica is handling
+// PROXY_MAX_CONCURRENCY requests at once, before any of them reach a
+// backend.
+func (p *ProxyServer) concurrencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !p.concurrency.acquire() {
+			concurrencyRejections.WithLabelValues("global").Inc()
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many concurrent requests", http.StatusServiceUnavailable)
+			return
+		}
+		defer p.concurrency.release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitMiddleware enforces the matched route's token-bucket rate limit,
+// shared across replicas via Redis. No-op for routes without a
+// proxy.ratelimit.rps tag.
+func (p *ProxyServer) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule, ok := ruleFromContext(r.Context())
+		if !ok || rule.RateLimitRPS <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := p.rateLimitKey(r, rule)
+		allowed, retryAfter, err := p.checkRateLimit(r.Context(), rule, key)
+		if err != nil {
+			slog.Warn("Rate limit check failed, allowing request", "service", rule.ServiceName, "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !allowed {
+			rateLimitRejections.WithLabelValues(rule.ServiceName, rateLimitKeySourceLabel(rule)).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitKeySourceLabel normalizes the route's configured key source for
+// the rate-limit metric label, defaulting to "ip".
+func rateLimitKeySourceLabel(rule *RouteRule) string {
+	if rule.RateLimitKeySource == "header" {
+		return "header"
+	}
+	return "ip"
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// tokenBucketScript atomically refills and consumes from a per-key token
+// bucket stored in a Redis hash, so the limit is shared across every proxy
+// replica instead of each one keeping its own local counter.
+//
+// KEYS[1] - bucket key
+// ARGV[1] - requests per second (refill rate)
+// ARGV[2] - burst (bucket capacity)
+// ARGV[3] - current time, unix seconds as a float
+//
+// Returns {allowed (0/1), seconds to wait before the next token is available}.
+var tokenBucketScript = redis.NewScript(`
+local key = KEYS[1]
+local rps = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local bucket = redis.call("HMGET", key, "tokens", "ts")
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil then
+	tokens = burst
+	ts = now
+end
+
+local elapsed = now - ts
+if elapsed > 0 then
+	tokens = math.min(burst, tokens + elapsed * rps)
+	ts = now
+end
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("HSET", key, "tokens", tokens, "ts", ts)
+redis.call("EXPIRE", key, math.ceil(burst / rps) + 1)
+
+local retry_after = 0
+if allowed == 0 then
+	retry_after = (1 - tokens) / rps
+end
+
+return {allowed, tostring(retry_after)}
+`)
+
+// checkRateLimit consumes one token from rule's bucket for key, returning
+// whether the request is allowed and, if not, how long the caller should
+// wait before retrying.
+func (p *ProxyServer) checkRateLimit(ctx context.Context, rule *RouteRule, key string) (bool, time.Duration, error) {
+	bucketKey := fmt.Sprintf("ratelimit:%s:%s:%s", rule.Cluster, rule.ServiceName, key)
+	now := float64(time.Now().UnixNano()) / float64(time.Second)
+
+	res, err := tokenBucketScript.Run(ctx, p.rdb, []string{bucketKey}, rule.RateLimitRPS, rule.RateLimitBurst, now).Result()
+	if err != nil {
+		return false, 0, err
+	}
+
+	arr, ok := res.([]interface{})
+	if !ok || len(arr) != 2 {
+		return false, 0, fmt.Errorf("unexpected rate limit script result: %v", res)
+	}
+	allowed, _ := arr[0].(int64)
+	retryAfterSeconds, _ := strconv.ParseFloat(fmt.Sprint(arr[1]), 64)
+
+	return allowed == 1, time.Duration(retryAfterSeconds * float64(time.Second)), nil
+}
+
+// rateLimitKey derives the per-client key a request is rate-limited under,
+// per the route's proxy.ratelimit.key tag.
+func (p *ProxyServer) rateLimitKey(r *http.Request, rule *RouteRule) string {
+	if rule.RateLimitKeySource == "header" && rule.RateLimitKeyHeader != "" {
+		if v := r.Header.Get(rule.RateLimitKeyHeader); v != "" {
+			return v
+		}
+	}
+	return p.clientIP(r)
+}
+
+// clientIP returns the request's client IP: the first hop of
+// X-Forwarded-For when RemoteAddr is a configured trusted proxy, the raw
+// remote address otherwise. Without the trust check, any client could set
+// its own X-Forwarded-For to land in a fresh rate-limit bucket on every
+// request.
+func (p *ProxyServer) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if p.trustedProxies.trusts(host) {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			return strings.TrimSpace(strings.SplitN(xff, ",", 2)[0])
+		}
+	}
+	return host
+}
+
+// trustedProxies holds the CIDR ranges of proxies allowed to set
+// X-Forwarded-For, from the PROXY_TRUSTED_PROXIES env var (comma-separated
+// CIDRs). Empty means no proxy is trusted and X-Forwarded-For is never
+// honored.
+type trustedProxies struct {
+	nets []*net.IPNet
+}
+
+func loadTrustedProxies() trustedProxies {
+	var nets []*net.IPNet
+	for _, part := range strings.Split(os.Getenv("PROXY_TRUSTED_PROXIES"), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(part)
+		if err != nil {
+			slog.Warn("Ignoring invalid PROXY_TRUSTED_PROXIES entry", "value", part, "error", err)
+			continue
+		}
+		nets = append(nets, ipNet)
+	}
+	return trustedProxies{nets: nets}
+}
+
+// trusts reports whether host, the peer RemoteAddr of a connection to this
+// proxy, is within a configured trusted-proxy range.
+func (t trustedProxies) trusts(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range t.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// globalConcurrency caps how many requests this replica proxies at once,
+// shedding load before the process itself falls over. Disabled (max <= 0)
+// by default.
+type globalConcurrency struct {
+	max int64
+	cur int64
+}
+
+func loadGlobalConcurrency() *globalConcurrency {
+	g := &globalConcurrency{}
+	if n, ok := envInt("PROXY_MAX_CONCURRENCY"); ok && n > 0 {
+		g.max = int64(n)
+	}
+	return g
+}
+
+func (g *globalConcurrency) acquire() bool {
+	if g.max <= 0 {
+		return true
+	}
+	if atomic.AddInt64(&g.cur, 1) > g.max {
+		atomic.AddInt64(&g.cur, -1)
+		return false
+	}
+	return true
+}
+
+func (g *globalConcurrency) release() {
+	if g.max <= 0 {
+		return
+	}
+	atomic.AddInt64(&g.cur, -1)
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// routesCacheKey is the Redis key the compiled route table is cached under,
+// shared by every proxy replica.
+const routesCacheKey = "proxy:routes"
+
+// routerConfig controls how services are exposed absent explicit tags.
+type routerConfig struct {
+	// exposedByDefault, when true, routes every ECS service that carries
+	// proxy.host and proxy.port tags even without an explicit
+	// proxy.enable=true. When
+	// false (the default), a service must opt in with proxy.enable=true.
+	exposedByDefault bool
+}
+
+func loadRouterConfig() routerConfig {
+	return routerConfig{
+		exposedByDefault: os.Getenv("PROXY_EXPOSED_BY_DEFAULT") == "true",
+	}
+}
+
+// RouteRule is a compiled host/path rule for one ECS service, built from its
+// proxy.* tags.
+type RouteRule struct {
+	ServiceName string
+	Cluster     string
+	Host        string
+	PathPrefix  string
+	Port        string
+	Weight      int
+	Sticky      string // "" or "cookie"
+
+	// LBStrategy is the service's chosen LoadBalancer, from the
+	// proxy.lb-strategy tag (see loadbalancer.go). Empty means round-robin.
+	LBStrategy string
+	// HashHeader is the request header the consistent-hash strategy keys
+	// on, from the proxy.lb-hash-header tag.
+	HashHeader string
+
+	// RateLimitRPS and RateLimitBurst configure the route's token-bucket
+	// rate limit, from the proxy.ratelimit.rps/proxy.ratelimit.burst tags.
+	// RateLimitRPS <= 0 means rate limiting is disabled for this route.
+	RateLimitRPS   float64
+	RateLimitBurst int
+	// RateLimitKeySource is "ip" (default) or "header", from the
+	// proxy.ratelimit.key tag. RateLimitKeyHeader names the header to key
+	// on when RateLimitKeySource is "header", from proxy.ratelimit.key-header.
+	RateLimitKeySource string
+	RateLimitKeyHeader string
+
+	// MaxTargetConcurrency caps in-flight requests per backend task, from
+	// the proxy.target-max-concurrency tag. Zero means no cap.
+	MaxTargetConcurrency int
+}
+
+// Router matches incoming requests against a set of compiled RouteRules,
+// Traefik-style: exact host match, longest path-prefix wins.
+type Router struct {
+	mu    sync.RWMutex
+	rules []*RouteRule
+}
+
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// setRules atomically replaces the active rule set, most specific
+// (longest path prefix) first.
+func (rt *Router) setRules(rules []*RouteRule) {
+	sorted := make([]*RouteRule, len(rules))
+	copy(sorted, rules)
+	sort.Slice(sorted, func(i, j int) bool {
+		return len(sorted[i].PathPrefix) > len(sorted[j].PathPrefix)
+	})
+
+	rt.mu.Lock()
+	rt.rules = sorted
+	rt.mu.Unlock()
+}
+
+// snapshot returns a copy of the currently active rules, safe to range over
+// without holding the router lock.
+func (rt *Router) snapshot() []*RouteRule {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	rules := make([]*RouteRule, len(rt.rules))
+	copy(rules, rt.rules)
+	return rules
+}
+
+// match resolves a request's Host header and URL path to the RouteRule that
+// should serve it.
+func (rt *Router) match(host, path string) (*RouteRule, bool) {
+	host = strings.SplitN(host, ":", 2)[0]
+
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	for _, rule := range rt.rules {
+		if rule.Host != host {
+			continue
+		}
+		if strings.HasPrefix(path, rule.PathPrefix) {
+			return rule, true
+		}
+	}
+	return nil, false
+}
+
+// routeFromTags builds a RouteRule for one ECS service from its tags,
+// reporting whether the service should be exposed at all.
+func (p *ProxyServer) routeFromTags(cluster, serviceName string, tags []ecsTypes.Tag) (*RouteRule, bool) {
+	tagMap := make(map[string]string, len(tags))
+	for _, t := range tags {
+		if t.Key != nil && t.Value != nil {
+			tagMap[*t.Key] = *t.Value
+		}
+	}
+
+	enabled := p.routerConfig.exposedByDefault
+	if v, ok := tagMap["proxy.enable"]; ok {
+		enabled = v == "true"
+	}
+	if !enabled {
+		return nil, false
+	}
+
+	rule := &RouteRule{
+		ServiceName:        serviceName,
+		Cluster:            cluster,
+		Host:               tagMap["proxy.host"],
+		PathPrefix:         tagMap["proxy.path-prefix"],
+		Port:               tagMap["proxy.port"],
+		Weight:             1,
+		Sticky:             tagMap["proxy.sticky"],
+		LBStrategy:         tagMap["proxy.lb-strategy"],
+		HashHeader:         tagMap["proxy.lb-hash-header"],
+		RateLimitKeySource: tagMap["proxy.ratelimit.key"],
+		RateLimitKeyHeader: tagMap["proxy.ratelimit.key-header"],
+	}
+
+	if rule.Host == "" || rule.Port == "" {
+		slog.Warn("Service is enabled for proxying but is missing proxy.host or proxy.port, skipping", "service", serviceName, "cluster", cluster)
+		return nil, false
+	}
+	if rule.PathPrefix == "" {
+		rule.PathPrefix = "/"
+	}
+	if w, ok := tagMap["proxy.weight"]; ok {
+		if n, err := strconv.Atoi(w); err == nil && n > 0 {
+			rule.Weight = n
+		}
+	}
+	if v, ok := tagMap["proxy.ratelimit.rps"]; ok {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 {
+			rule.RateLimitRPS = f
+		}
+	}
+	if v, ok := tagMap["proxy.ratelimit.burst"]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			rule.RateLimitBurst = n
+		}
+	}
+	if rule.RateLimitRPS > 0 && rule.RateLimitBurst <= 0 {
+		// Default burst to one second's worth of tokens, rounded up, when
+		// the tag didn't set one explicitly.
+		rule.RateLimitBurst = int(math.Ceil(rule.RateLimitRPS))
+		if rule.RateLimitBurst < 1 {
+			rule.RateLimitBurst = 1
+		}
+	}
+	if v, ok := tagMap["proxy.target-max-concurrency"]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			rule.MaxTargetConcurrency = n
+		}
+	}
+
+	return rule, true
+}
+
+// listClusters returns every ECS cluster ARN visible to this account, paging
+// through ListClusters as needed.
+func (p *ProxyServer) listClusters(ctx context.Context) ([]string, error) {
+	var clusters []string
+	input := &ecs.ListClustersInput{}
+	for {
+		out, err := p.ecsClient.ListClusters(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		clusters = append(clusters, out.ClusterArns...)
+		if out.NextToken == nil {
+			break
+		}
+		input.NextToken = out.NextToken
+	}
+	return clusters, nil
+}
+
+// listServices returns every service ARN in cluster, paging through
+// ListServices as needed.
+func (p *ProxyServer) listServices(ctx context.Context, cluster string) ([]string, error) {
+	var services []string
+	input := &ecs.ListServicesInput{Cluster: &cluster}
+	for {
+		out, err := p.ecsClient.ListServices(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, out.ServiceArns...)
+		if out.NextToken == nil {
+			break
+		}
+		input.NextToken = out.NextToken
+	}
+	return services, nil
+}
+
+// describeServicesBatchSize is the maximum number of services the ECS API
+// accepts in a single DescribeServices call.
+const describeServicesBatchSize = 10
+
+// discoverRoutes lists every ECS cluster and service reachable from this
+// account, reads each service's proxy.* tags, and returns the resulting
+// route table.
+func (p *ProxyServer) discoverRoutes(ctx context.Context) ([]*RouteRule, error) {
+	clusters, err := p.listClusters(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []*RouteRule
+	for _, clusterArn := range clusters {
+		serviceArns, err := p.listServices(ctx, clusterArn)
+		if err != nil {
+			slog.Error("listServices failed", "cluster", clusterArn, "error", err)
+			continue
+		}
+
+		// RouteRule.Cluster (and every cache key derived from it) uses the
+		// short cluster name, not the ARN, to match clusterNameFromArn in
+		// ecsevents.go and keep "cluster:service" cache keys free of the
+		// colons an ARN itself contains.
+		clusterName := clusterNameFromArn(clusterArn)
+
+		for start := 0; start < len(serviceArns); start += describeServicesBatchSize {
+			end := start + describeServicesBatchSize
+			if end > len(serviceArns) {
+				end = len(serviceArns)
+			}
+			batch := serviceArns[start:end]
+
+			out, err := p.ecsClient.DescribeServices(ctx, &ecs.DescribeServicesInput{
+				Cluster:  &clusterArn,
+				Services: batch,
+				Include:  []ecsTypes.ServiceField{ecsTypes.ServiceFieldTags},
+			})
+			if err != nil {
+				slog.Error("DescribeServices failed", "cluster", clusterArn, "error", err)
+				continue
+			}
+
+			for _, svc := range out.Services {
+				if svc.ServiceName == nil {
+					continue
+				}
+				if rule, ok := p.routeFromTags(clusterName, *svc.ServiceName, svc.Tags); ok {
+					rules = append(rules, rule)
+				}
+			}
+		}
+	}
+
+	return rules, nil
+}
+
+// refreshRoutes rediscovers the route table from ECS, caches it in Redis for
+// other replicas, and swaps it into the live Router.
+func (p *ProxyServer) refreshRoutes(ctx context.Context) {
+	rules, err := p.discoverRoutes(ctx)
+	if err != nil {
+		slog.Error("refreshRoutes failed", "error", err)
+		return
+	}
+
+	if encoded, err := json.Marshal(rules); err == nil {
+		p.rdb.Set(ctx, routesCacheKey, string(encoded), p.cacheDuration)
+	}
+
+	p.router.setRules(rules)
+	slog.Info("Route table refreshed", "routes", len(rules))
+}
+
+// loadRoutes returns the route table, preferring the shared Redis cache over
+// a full ECS rediscovery.
+func (p *ProxyServer) loadRoutes(ctx context.Context) ([]*RouteRule, error) {
+	if cached, err := p.rdb.Get(ctx, routesCacheKey).Result(); err == nil {
+		var rules []*RouteRule
+		if err := json.Unmarshal([]byte(cached), &rules); err == nil {
+			return rules, nil
+		}
+	}
+
+	rules, err := p.discoverRoutes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if encoded, err := json.Marshal(rules); err == nil {
+		p.rdb.Set(ctx, routesCacheKey, string(encoded), p.cacheDuration)
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+)
+
+// tlsSettings controls the optional HTTPS listener, loaded once at startup
+// from environment variables. TLS is off by default; existing deployments
+// keep serving plain HTTP on the :6969 listener unchanged.
+type tlsSettings struct {
+	enabled bool
+	addr    string
+
+	// Static certificate, used when acmeEnabled is false.
+	certFile string
+	keyFile  string
+
+	// ACME (Let's Encrypt) auto-issuance, keyed by the hostnames the proxy
+	// actually routes (see acmeHostPolicy).
+	acmeEnabled bool
+	acmeEmail   string
+
+	hstsEnabled     bool
+	redirectToHTTPS bool
+}
+
+func loadTLSSettings() tlsSettings {
+	cfg := tlsSettings{
+		enabled: os.Getenv("PROXY_TLS_ENABLED") == "true",
+		addr:    ":443",
+
+		certFile: os.Getenv("PROXY_TLS_CERT_FILE"),
+		keyFile:  os.Getenv("PROXY_TLS_KEY_FILE"),
+
+		acmeEnabled: os.Getenv("PROXY_TLS_ACME_ENABLED") == "true",
+		acmeEmail:   os.Getenv("PROXY_TLS_ACME_EMAIL"),
+
+		hstsEnabled:     os.Getenv("PROXY_HSTS_ENABLED") == "true",
+		redirectToHTTPS: os.Getenv("PROXY_REDIRECT_HTTP_TO_HTTPS") == "true",
+	}
+	if v := os.Getenv("PROXY_TLS_ADDR"); v != "" {
+		cfg.addr = v
+	}
+	return cfg
+}
+
+// acmeCacheKeyPrefix namespaces ACME account keys and issued certificates in
+// Redis, shared by every proxy replica so a restart or a new replica never
+// re-issues a certificate that already exists.
+const acmeCacheKeyPrefix = "acme:cache:"
+
+// redisACMECache implements autocert.Cache on top of Redis so account keys
+// and certificates survive restarts and are shared across replicas, instead
+// of each one maintaining its own autocert dir cache.
+type redisACMECache struct {
+	rdb *redis.Client
+}
+
+func newRedisACMECache(p *ProxyServer) *redisACMECache {
+	return &redisACMECache{rdb: p.rdb}
+}
+
+func (c *redisACMECache) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := c.rdb.Get(ctx, acmeCacheKeyPrefix+key).Bytes()
+	if errors.Is(err, context.Canceled) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, autocert.ErrCacheMiss
+	}
+	return data, nil
+}
+
+func (c *redisACMECache) Put(ctx context.Context, key string, data []byte) error {
+	// No TTL: certificates and account keys are long-lived and autocert
+	// manages their renewal itself.
+	return c.rdb.Set(ctx, acmeCacheKeyPrefix+key, data, 0).Err()
+}
+
+func (c *redisACMECache) Delete(ctx context.Context, key string) error {
+	return c.rdb.Del(ctx, acmeCacheKeyPrefix+key).Err()
+}
+
+// acmeHostPolicy restricts certificate issuance to hostnames the proxy is
+// actually configured to route, learned from the live Router rather than a
+// static allowlist, so an attacker can't make the proxy request arbitrary
+// certificates on its behalf.
+func (p *ProxyServer) acmeHostPolicy() autocert.HostPolicy {
+	return func(_ context.Context, host string) error {
+		for _, rule := range p.router.snapshot() {
+			if rule.Host == host {
+				return nil
+			}
+		}
+		return fmt.Errorf("acme/autocert: host %s is not a routed hostname", host)
+	}
+}
+
+// newACMEManager builds the single autocert.Manager shared by both the
+// HTTPS listener (TLSConfig) and the :80 listener (HTTPHandler). A manager's
+// in-flight HTTP-01 challenge tokens live only in its own memory, not in the
+// Cache it's given, so the listener validating a challenge must be the same
+// instance that started issuing the certificate — two separate Managers
+// would never agree on a pending token.
+func (p *ProxyServer) newACMEManager(cfg tlsSettings) *autocert.Manager {
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      newRedisACMECache(p),
+		HostPolicy: p.acmeHostPolicy(),
+		Email:      cfg.acmeEmail,
+	}
+}
+
+// tlsConfig builds the *tls.Config for the HTTPS listener, either from a
+// static certificate pair or manager's ACME-backed TLSConfig.
+func (p *ProxyServer) tlsConfig(cfg tlsSettings, manager *autocert.Manager) (*tls.Config, error) {
+	if cfg.acmeEnabled {
+		return manager.TLSConfig(), nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.certFile, cfg.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}, nil
+}
+
+// hstsMiddleware adds Strict-Transport-Security to every response when
+// enabled. It's meant to wrap the handler served on the HTTPS listener only.
+func hstsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains; preload")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// redirectToHTTPSHandler responds to any plain-HTTP request with a redirect
+// to the same host/path over HTTPS.
+func redirectToHTTPSHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := "https://" + r.Host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
+
+// runTLSServer starts the optional HTTPS listener. It blocks, so callers
+// should invoke it in its own goroutine. No-op if TLS isn't enabled. manager
+// is nil unless cfg.acmeEnabled, in which case it must be the same instance
+// passed to runRedirectServer.
+func (p *ProxyServer) runTLSServer(cfg tlsSettings, manager *autocert.Manager) {
+	if !cfg.enabled {
+		return
+	}
+
+	tc, err := p.tlsConfig(cfg, manager)
+	if err != nil {
+		slog.Error("Failed to build TLS config, HTTPS listener not started", "error", err)
+		return
+	}
+
+	var handler http.Handler = p.Handler()
+	if cfg.hstsEnabled {
+		handler = hstsMiddleware(handler)
+	}
+
+	server := &http.Server{
+		Addr:         cfg.addr,
+		Handler:      handler,
+		TLSConfig:    tc,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  30 * time.Second,
+	}
+	if err := http2.ConfigureServer(server, &http2.Server{}); err != nil {
+		slog.Warn("Failed to configure HTTP/2 on HTTPS listener, continuing with HTTP/1.1", "error", err)
+	}
+
+	slog.Info("HTTPS server listening", "addr", cfg.addr, "acme", cfg.acmeEnabled)
+	if err := server.ListenAndServeTLS("", ""); err != nil {
+		slog.Error("HTTPS server stopped", "error", err)
+	}
+}
+
+// runRedirectServer serves plain-HTTP ACME HTTP-01 challenge responses (when
+// an autocert.Manager is in use) and, if configured, redirects every other
+// request to HTTPS. It blocks, so callers should invoke it in its own
+// goroutine. No-op unless TLS and at least one of ACME or the redirect
+// option is enabled. manager is nil unless cfg.acmeEnabled, in which case it
+// must be the same instance passed to runTLSServer.
+func (p *ProxyServer) runRedirectServer(cfg tlsSettings, manager *autocert.Manager) {
+	if !cfg.enabled || (!cfg.acmeEnabled && !cfg.redirectToHTTPS) {
+		return
+	}
+
+	fallback := p.Handler()
+	if cfg.redirectToHTTPS {
+		fallback = redirectToHTTPSHandler()
+	}
+
+	var handler http.Handler = fallback
+	if cfg.acmeEnabled {
+		handler = manager.HTTPHandler(fallback)
+	}
+
+	slog.Info("HTTP redirect/ACME-challenge server listening", "addr", ":80")
+	if err := http.ListenAndServe(":80", handler); err != nil {
+		slog.Error("HTTP redirect server stopped", "error", err)
+	}
+}
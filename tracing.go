@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/MelloB1989/ecs.proxy.go"
+
+// initTracing wires up an OpenTelemetry TracerProvider exporting spans over
+// OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT is set, and a no-op provider
+// otherwise so tracer() is always safe to call. It returns a shutdown func
+// to be deferred from main.
+func initTracing(ctx context.Context) func(context.Context) error {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	if endpoint == "" {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		return func(context.Context) error { return nil }
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		slog.Error("Failed to create OTLP trace exporter, tracing disabled", "error", err)
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		return func(context.Context) error { return nil }
+	}
+
+	res, err := resource.New(ctx, resource.WithAttributes(
+		semconv.ServiceName("ecs-proxy"),
+	))
+	if err != nil {
+		res = resource.Default()
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	slog.Info("OpenTelemetry tracing enabled", "endpoint", endpoint)
+	return tp.Shutdown
+}
+
+// tracer returns this service's tracer. Safe to call before initTracing if
+// ever necessary, since the global TracerProvider defaults to a no-op.
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
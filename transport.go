@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// hopHeaders are per-hop headers that must not be forwarded upstream (RFC
+// 7230 section 6.1). Connection and Upgrade are handled separately below so
+// WebSocket and other protocol-upgrade requests keep working.
+var hopHeaders = []string{
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+}
+
+// upstreamTransport is shared by every proxied request. HTTP/2 is
+// configured explicitly so an upstream that speaks h2 over TLS is used
+// efficiently instead of silently falling back to HTTP/1.1.
+var upstreamTransport = newUpstreamTransport()
+
+func newUpstreamTransport() http.RoundTripper {
+	t := &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          200,
+		MaxIdleConnsPerHost:   50,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	if err := http2.ConfigureTransport(t); err != nil {
+		slog.Warn("Failed to configure HTTP/2 upstream transport, continuing with HTTP/1.1", "error", err)
+	}
+	return t
+}
+
+// isUpgradeRequest reports whether r is a protocol-upgrade request (e.g. a
+// WebSocket handshake), which needs its Connection/Upgrade headers and
+// underlying connection passed through untouched.
+func isUpgradeRequest(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Connection"), "upgrade") && r.Header.Get("Upgrade") != ""
+}
+
+// newReverseProxy builds a *httputil.ReverseProxy to target whose Director
+// strips hop-by-hop headers but leaves Connection/Upgrade alone on
+// protocol-upgrade requests, since httputil's default header handling isn't
+// enough to make WebSocket passthrough reliable end to end.
+func newReverseProxy(target *url.URL) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(target)
+
+	defaultDirector := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		defaultDirector(r)
+
+		upgrade := isUpgradeRequest(r)
+		for _, h := range hopHeaders {
+			r.Header.Del(h)
+		}
+		if !upgrade {
+			r.Header.Del("Connection")
+			r.Header.Del("Upgrade")
+		}
+	}
+
+	proxy.Transport = upstreamTransport
+	return proxy
+}